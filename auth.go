@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/SermoDigital/jose/jwt"
+	"github.com/go-kit/kit/metrics"
+	"github.com/spf13/viper"
+	"github.com/xmidt-org/bascule"
+	"github.com/xmidt-org/bascule/basculehttp"
+	"github.com/xmidt-org/webpa-common/secure"
+)
+
+// This file assumes the following shapes from github.com/xmidt-org/bascule
+// and github.com/xmidt-org/webpa-common/secure:
+//
+//   bascule.Token interface { Principal() string; Type() string; Attributes() Attributes }
+//   bascule.Attributes interface { Get(key string) (interface{}, bool); Keys() []string }
+//   bascule.Authentication struct { Authorization Authorization; Token Token; Request Request }
+//   bascule.Request struct { URL *url.URL; Method string }
+//   bascule.FromContext(ctx) (Authentication, bool)
+//   basculehttp.TokenFactory interface { ParseAndValidate(ctx, *http.Request, basculehttp.Authorization, string) (bascule.Token, error) }
+//   secure.NewToken(tokenType, value string) *secure.Token
+//   secure.Validator interface { Validate(ctx, *secure.Token) (bool, error) }
+//
+// This module has no go.mod/vendored deps in this tree, so these can't be
+// pinned to released versions or checked with `go build`; they match the
+// bascule/basculehttp/webpa-common versions caduceus was on at the time of
+// the secure -> bascule migration. Re-verify against go.sum once this lands
+// in a tree that has one.
+
+// capabilityCheckMetric counts capability-check outcomes, tagged with an
+// "outcome" label of "accepted" or "rejected". It's kept distinct from the
+// 401/403 counters basculehttp already emits for missing/invalid tokens so
+// operators can tell "who is this" apart from "are they allowed to do
+// that".
+const capabilityCheckMetric = "auth_capability_check"
+
+// errInvalidCredential is returned by legacyTokenFactory when the legacy
+// webpa-common/secure validators reject the credential.
+var errInvalidCredential = errors.New("invalid credential")
+
+// capabilitiesAttributeKey is the attribute a token's capabilities are
+// stored under, whether the token came from a JWT "capabilities" claim or
+// was synthesized by legacyTokenFactory.
+const capabilitiesAttributeKey = "capabilities"
+
+// BasculeConfig is the `bascule` viper section added alongside the legacy
+// jwtValidators/authHeader shape while the auth stack migrates off
+// webpa-common/secure. It configures the capability check layered on top
+// of the existing signature/basic-auth validation.
+type BasculeConfig struct {
+	// CapabilityPrefix is prepended to each entry in EndpointRegexes to
+	// build the patterns a capability's non-method portion is matched
+	// against, e.g. "x1:webpa:api:".
+	CapabilityPrefix string
+
+	// AcceptAllMethod is the capability method segment that matches any
+	// HTTP method, e.g. "all".
+	AcceptAllMethod string
+
+	// EndpointRegexes are matched, with CapabilityPrefix prepended,
+	// against the portion of a capability before its trailing ":<method>"
+	// segment, e.g. ".*" to allow any endpoint.
+	EndpointRegexes []string
+
+	// ErrorOnMissingCapabilities rejects tokens that carry no capabilities
+	// claim at all. Defaults to false so deployments whose tokens don't
+	// carry capabilities yet keep working during the migration.
+	ErrorOnMissingCapabilities bool
+}
+
+// newBasculeConfig reads the bascule viper section, defaulting
+// AcceptAllMethod to "all" so a bare `bascule:` section with only
+// endpointRegexes configured behaves sensibly.
+func newBasculeConfig(v *viper.Viper) BasculeConfig {
+	cfg := BasculeConfig{AcceptAllMethod: "all"}
+	v.UnmarshalKey("bascule", &cfg)
+	return cfg
+}
+
+// capabilitiesValidator is a bascule.Validator, modeled on
+// basculechecks.CapabilitiesValidator, that asserts the authenticated
+// token carries a capability whose method segment matches the request's
+// method and whose remaining portion matches one of the configured
+// endpoint regexes. The request's URL path is not compared against the
+// capability. Acceptance/rejection is
+// reported through counter so operators can distinguish "rejected for lack
+// of capability" from the generic 401/403 rate.
+type capabilitiesValidator struct {
+	cfg      BasculeConfig
+	patterns []*regexp.Regexp
+	counter  metrics.Counter
+}
+
+// newCapabilitiesValidator compiles cfg's endpoint regexes up front so
+// Check never pays compilation cost per request.
+func newCapabilitiesValidator(cfg BasculeConfig, counter metrics.Counter) (*capabilitiesValidator, error) {
+	patterns := make([]*regexp.Regexp, 0, len(cfg.EndpointRegexes))
+	for _, expr := range cfg.EndpointRegexes {
+		pattern, err := regexp.Compile(cfg.CapabilityPrefix + expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bascule endpoint regex %q: %v", expr, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+
+	return &capabilitiesValidator{cfg: cfg, patterns: patterns, counter: counter}, nil
+}
+
+// Check implements bascule.Validator.
+func (c *capabilitiesValidator) Check(ctx context.Context, token bascule.Token) error {
+	capabilities, ok := capabilitiesOf(token)
+	if !ok || len(capabilities) == 0 {
+		if c.cfg.ErrorOnMissingCapabilities {
+			c.observe(false)
+			return fmt.Errorf("token for %s carries no capabilities", token.Principal())
+		}
+		c.observe(true)
+		return nil
+	}
+
+	auth, ok := bascule.FromContext(ctx)
+	if !ok {
+		c.observe(false)
+		return errors.New("no request in context")
+	}
+	method := strings.ToLower(auth.Request.Method)
+
+	for _, capability := range capabilities {
+		if !c.authorizes(capability, method) {
+			continue
+		}
+		c.observe(true)
+		return nil
+	}
+
+	c.observe(false)
+	return fmt.Errorf("none of capabilities %v authorize %s for %s", capabilities, method, token.Principal())
+}
+
+// authorizes reports whether capability authorizes method. A capability is
+// expected to end in a ":<method>" segment - either the literal method
+// (e.g. "post") or AcceptAllMethod (e.g. "all") to match any method -
+// everything before that segment is matched against the compiled endpoint
+// patterns.
+func (c *capabilitiesValidator) authorizes(capability, method string) bool {
+	idx := strings.LastIndex(capability, ":")
+	if idx < 0 {
+		return false
+	}
+
+	path, capMethod := capability[:idx], capability[idx+1:]
+	if capMethod != c.cfg.AcceptAllMethod && capMethod != method {
+		return false
+	}
+
+	for _, pattern := range c.patterns {
+		if pattern.MatchString(path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *capabilitiesValidator) observe(accepted bool) {
+	if c.counter == nil {
+		return
+	}
+	outcome := "rejected"
+	if accepted {
+		outcome = "accepted"
+	}
+	c.counter.With("outcome", outcome).Add(1)
+}
+
+// capabilitiesOf extracts a token's capabilities attribute, tolerating
+// both []string and []interface{} (the shape a msgpack/JSON-decoded JWT
+// claim typically takes, and the one legacyTokenFactory stores).
+func capabilitiesOf(token bascule.Token) ([]string, bool) {
+	raw, ok := token.Attributes().Get(capabilitiesAttributeKey)
+	if !ok {
+		return nil, false
+	}
+
+	switch values := raw.(type) {
+	case []string:
+		return values, true
+	case []interface{}:
+		capabilities := make([]string, 0, len(values))
+		for _, value := range values {
+			if s, ok := value.(string); ok {
+				capabilities = append(capabilities, s)
+			}
+		}
+		return capabilities, true
+	default:
+		return nil, false
+	}
+}
+
+// mapAttributes is a bascule.Attributes backed by a plain map, used to
+// carry the claims legacyTokenFactory and the default LogConstructor read
+// out of legacy and JWT tokens alike.
+type mapAttributes map[string]interface{}
+
+func (a mapAttributes) Get(key string) (interface{}, bool) {
+	value, ok := a[key]
+	return value, ok
+}
+
+func (a mapAttributes) Keys() []string {
+	keys := make([]string, 0, len(a))
+	for key := range a {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// legacyToken is the bascule.Token legacyTokenFactory produces: a
+// principal plus whatever attributes could be recovered from the legacy
+// credential.
+type legacyToken struct {
+	tokenType  string
+	principal  string
+	attributes mapAttributes
+}
+
+func (t legacyToken) Principal() string { return t.principal }
+func (t legacyToken) Type() string      { return t.tokenType }
+
+func (t legacyToken) Attributes() bascule.Attributes { return t.attributes }
+
+// legacyTokenFactory bridges the webpa-common/secure validators this
+// service has always supported (JWS-signed JWTs and exact-match basic
+// auth tokens) into the basculehttp.TokenFactory interface, so the new
+// basculehttp constructor/enforcer chain can sit in front of them without
+// dropping support for existing clients while capability checks roll out.
+type legacyTokenFactory struct {
+	scheme    string
+	validator secure.Validator
+}
+
+// ParseAndValidate implements basculehttp.TokenFactory.
+func (f legacyTokenFactory) ParseAndValidate(ctx context.Context, _ *http.Request, _ basculehttp.Authorization, value string) (bascule.Token, error) {
+	valid, err := f.validator.Validate(ctx, secure.NewToken(f.scheme, value))
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, errInvalidCredential
+	}
+
+	principal, attributes := parseLegacyCredential(f.scheme, value)
+	return legacyToken{tokenType: f.scheme, principal: principal, attributes: attributes}, nil
+}
+
+// parseLegacyCredential recovers a principal and, for JWTs, a capabilities
+// claim from a credential already known (by the caller) to have passed
+// legacy validation. It never fails: a credential that can't be parsed
+// just yields an empty principal and no capabilities, same as any other
+// token with no capabilities claim.
+func parseLegacyCredential(scheme, value string) (string, mapAttributes) {
+	switch scheme {
+	case "Basic":
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return "", nil
+		}
+		principal := strings.SplitN(string(decoded), ":", 2)[0]
+		return principal, nil
+	case "Bearer":
+		token, err := jwt.Parse(value)
+		if err != nil {
+			return "", nil
+		}
+
+		claims := token.Claims()
+		principal, _ := claims.Get("sub").(string)
+
+		attributes := make(mapAttributes)
+		if capabilities, ok := claims.Get(capabilitiesAttributeKey).([]interface{}); ok {
+			attributes[capabilitiesAttributeKey] = capabilities
+		}
+		if partnerIDs := claims.Get("partner-id"); partnerIDs != nil {
+			attributes["partner-id"] = partnerIDs
+		}
+
+		return principal, attributes
+	default:
+		return "", nil
+	}
+}
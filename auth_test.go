@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapabilitiesValidatorAuthorizes(t *testing.T) {
+	cfg := BasculeConfig{
+		CapabilityPrefix: "x1:webpa:api:",
+		AcceptAllMethod:  "all",
+		EndpointRegexes:  []string{"notify"},
+	}
+
+	validator, err := newCapabilitiesValidator(cfg, nil)
+	assert.NoError(t, err)
+
+	assert.True(t, validator.authorizes("x1:webpa:api:notify:post", "post"))
+	assert.True(t, validator.authorizes("x1:webpa:api:notify:all", "get"))
+	assert.False(t, validator.authorizes("x1:webpa:api:notify:post", "delete"))
+	assert.False(t, validator.authorizes("x1:webpa:api:other:post", "post"))
+	assert.False(t, validator.authorizes("no-method-segment", "post"))
+}
+
+func TestNewCapabilitiesValidatorInvalidRegex(t *testing.T) {
+	cfg := BasculeConfig{EndpointRegexes: []string{"("}}
+
+	_, err := newCapabilitiesValidator(cfg, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/xmidt-org/bascule"
+	"github.com/xmidt-org/candlelight"
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+// transactionIDHeaderKey is the header xmidt clients use to propagate a
+// caller-assigned request ID; when absent, one is generated so every
+// request still gets a requestID in its logs.
+const transactionIDHeaderKey = "X-Webpa-Transaction-Id"
+
+// defaultDeviceIDPeekLimit bounds how much of a notify body deviceIDOf will
+// buffer in memory just to pull a log field out of it. Operators can raise,
+// lower, or disable (<= 0) this via the log.deviceIDPeekLimit viper key.
+const defaultDeviceIDPeekLimit = 64 * 1024
+
+// LogConstructor builds the logger attached to a request's context. It runs
+// once per request, after authentication, so the default implementation can
+// enrich base with the authenticated subject and partner IDs alongside
+// trace info and the sanitized method/URL. Handlers further down the chain
+// should call GetLogger(ctx) rather than re-deriving these fields
+// themselves, so log lines from the same request carry consistent
+// requestID, traceID, spanID, authSubject, authPartnerIDs, and deviceID
+// fields.
+type LogConstructor func(base log.Logger, r *http.Request) log.Logger
+
+// newDefaultLogConstructor returns the LogConstructor used when
+// NewPrimaryHandler isn't given one explicitly. It tags the logger with a
+// requestID, trace info, the sanitized request headers/method/URL, the
+// authenticated subject/partner IDs (once bascule's constructor/enforcer
+// middleware has run), and - for msgpack notify payloads - the device ID.
+func newDefaultLogConstructor(denyList []string, deviceIDPeekLimit int64) LogConstructor {
+	return func(base log.Logger, r *http.Request) log.Logger {
+		kvs := []interface{}{
+			"requestID", requestIDOf(r),
+			"requestHeaders", sanitizeHeaders(r.Header, denyList),
+			"requestURL", r.URL.EscapedPath(),
+			"method", r.Method,
+		}
+		kvs, _ = candlelight.AppendTraceInfo(r.Context(), kvs)
+
+		if auth, ok := bascule.FromContext(r.Context()); ok {
+			kvs = append(kvs, "authSubject", auth.Token.Principal())
+			if partnerIDs, ok := auth.Token.Attributes().Get("partner-id"); ok {
+				kvs = append(kvs, "authPartnerIDs", partnerIDs)
+			}
+		}
+
+		if deviceID, ok := deviceIDOf(r, deviceIDPeekLimit); ok {
+			kvs = append(kvs, "deviceID", deviceID)
+		}
+
+		return log.With(base, kvs...)
+	}
+}
+
+// requestIDOf returns the caller-supplied transaction ID, or generates one
+// if the request didn't carry one.
+func requestIDOf(r *http.Request) string {
+	if id := r.Header.Get(transactionIDHeaderKey); id != "" {
+		return id
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(buf)
+}
+
+// deviceIDOf peeks at a msgpack-encoded wrp.Message body to recover its
+// destination device ID for logging, restoring r.Body afterward so the
+// handler that actually processes the request still sees the full
+// payload. peekLimit <= 0 disables this entirely; a body whose declared
+// Content-Length exceeds peekLimit, or whose length isn't known up front,
+// is left untouched rather than buffered in full just for a log field.
+// Any non-msgpack request, or one whose body can't be decoded, yields no
+// deviceID - this is a best-effort enrichment, not validation.
+func deviceIDOf(r *http.Request, peekLimit int64) (string, bool) {
+	if peekLimit <= 0 || r.Body == nil {
+		return "", false
+	}
+	if !strings.Contains(r.Header.Get("Content-Type"), "msgpack") {
+		return "", false
+	}
+	if r.ContentLength < 0 || r.ContentLength > peekLimit {
+		return "", false
+	}
+
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return "", false
+	}
+
+	var msg wrp.Message
+	if err := wrp.NewDecoder(bytes.NewReader(body), wrp.Msgpack).Decode(&msg); err != nil {
+		return "", false
+	}
+
+	return msg.Destination, msg.Destination != ""
+}
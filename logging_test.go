@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+func encodeMsgpack(t *testing.T, msg *wrp.Message) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	assert.NoError(t, wrp.NewEncoder(&buf, wrp.Msgpack).Encode(msg))
+	return buf.Bytes()
+}
+
+func TestDeviceIDOfRestoresBodyAndReturnsID(t *testing.T) {
+	payload := encodeMsgpack(t, &wrp.Message{Destination: "mac:112233445566"})
+
+	req := httptest.NewRequest("POST", "/api/v3/notify", bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/msgpack")
+
+	deviceID, ok := deviceIDOf(req, defaultDeviceIDPeekLimit)
+	assert.True(t, ok)
+	assert.Equal(t, "mac:112233445566", deviceID)
+
+	restored, err := io.ReadAll(req.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, payload, restored)
+}
+
+func TestDeviceIDOfDisabled(t *testing.T) {
+	payload := encodeMsgpack(t, &wrp.Message{Destination: "mac:112233445566"})
+
+	req := httptest.NewRequest("POST", "/api/v3/notify", bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/msgpack")
+
+	deviceID, ok := deviceIDOf(req, 0)
+	assert.False(t, ok)
+	assert.Empty(t, deviceID)
+
+	restored, err := io.ReadAll(req.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, payload, restored)
+}
+
+func TestDeviceIDOfSkipsOversizedBody(t *testing.T) {
+	payload := encodeMsgpack(t, &wrp.Message{Destination: "mac:112233445566"})
+
+	req := httptest.NewRequest("POST", "/api/v3/notify", bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/msgpack")
+
+	deviceID, ok := deviceIDOf(req, int64(len(payload)-1))
+	assert.False(t, ok)
+	assert.Empty(t, deviceID)
+
+	restored, err := io.ReadAll(req.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, payload, restored)
+}
@@ -0,0 +1,19 @@
+package main
+
+import "github.com/xmidt-org/webpa-common/xmetrics"
+
+// Metrics returns this package's metric definitions for inclusion in the
+// server's overall xmetrics.Metrics() registration. Without an entry here,
+// metricsRegistry.NewCounter(capabilityCheckMetric) and the "outcome"
+// label capabilitiesValidator partitions by are unregistered, and a
+// Prometheus-backed provider panics the first time they're used.
+func Metrics() []xmetrics.Metric {
+	return []xmetrics.Metric{
+		{
+			Name:       capabilityCheckMetric,
+			Type:       "counter",
+			Help:       "Count of capability check outcomes, partitioned by whether the request was accepted or rejected",
+			LabelNames: []string{"outcome"},
+		},
+	}
+}
@@ -1,11 +1,11 @@
 package main
 
 import (
-	"fmt"
 	"github.com/xmidt-org/bascule"
-	"github.com/xmidt-org/candlelight"
+	"github.com/xmidt-org/bascule/basculehttp"
 	"github.com/xmidt-org/webpa-common/logging"
 	"net/http"
+	"strings"
 
 	"context"
 	"github.com/SermoDigital/jose/jwt"
@@ -16,7 +16,6 @@ import (
 	"github.com/spf13/viper"
 	"github.com/xmidt-org/ancla"
 	"github.com/xmidt-org/webpa-common/secure"
-	"github.com/xmidt-org/webpa-common/secure/handler"
 	"github.com/xmidt-org/webpa-common/secure/key"
 )
 
@@ -34,13 +33,54 @@ type JWTValidator struct {
 	Custom secure.JWTValidatorFactory
 }
 
-func SetLogger(logger log.Logger) func(delegate http.Handler) http.Handler {
+const (
+	authorizationHeaderKey     = "Authorization"
+	authorizationTypeHeaderKey = "Authorization-Type"
+)
+
+// defaultSanitizeHeaders is the baseline deny-list of headers stripped from
+// a request before it is logged. Authorization is always sanitized and is
+// handled separately so that its scheme is preserved. Operators may extend
+// this list via the log.sanitizeHeaders viper key.
+var defaultSanitizeHeaders = []string{"Cookie", "X-Auth-Token", "Proxy-Authorization"}
+
+// sanitizeHeaders returns a clone of header with Authorization and any
+// headers named in denyList removed. Authorization isn't dropped outright:
+// it's replaced with Authorization-Type, holding just the scheme token (the
+// first space-separated field, e.g. "Bearer" or "Basic") so logs and trace
+// exporters retain enough context to debug auth failures without seeing
+// the credential that follows the scheme. A value with no space has no
+// separate scheme and is kept whole under Authorization-Type.
+func sanitizeHeaders(header http.Header, denyList []string) http.Header {
+	sanitized := header.Clone()
+	if sanitized == nil {
+		return sanitized
+	}
+
+	if values := sanitized[authorizationHeaderKey]; len(values) > 0 {
+		types := make([]string, 0, len(values))
+		for _, value := range values {
+			types = append(types, strings.SplitN(value, " ", 2)[0])
+		}
+
+		sanitized.Del(authorizationHeaderKey)
+		sanitized[authorizationTypeHeaderKey] = types
+	}
+
+	for _, key := range denyList {
+		sanitized.Del(key)
+	}
+
+	return sanitized
+}
+
+// SetLogger returns middleware that builds the request-scoped logger via lc
+// and stashes it on the request context, where GetLogger retrieves it.
+func SetLogger(logger log.Logger, lc LogConstructor) func(delegate http.Handler) http.Handler {
 	return func(delegate http.Handler) http.Handler {
 		return http.HandlerFunc(
 			func(w http.ResponseWriter, r *http.Request) {
-				kvs := []interface{}{"requestHeaders", r.Header, "requestURL", r.URL.EscapedPath(), "method", r.Method}
-				kvs, _ = candlelight.AppendTraceInfo(r.Context(), kvs)
-				ctx := r.WithContext(logging.WithLogger(r.Context(), log.With(logger, kvs...)))
+				ctx := r.WithContext(logging.WithLogger(r.Context(), lc(logger, r)))
 				delegate.ServeHTTP(w, ctx)
 			})
 	}
@@ -51,31 +91,77 @@ func GetLogger(ctx context.Context) bascule.Logger {
 	return logger
 }
 
-func NewPrimaryHandler(l log.Logger, v *viper.Viper, sw *ServerHandler, webhookSvc ancla.Service, metricsRegistry provider.Provider, router *mux.Router) (*mux.Router, error) {
+// PrimaryHandlerOption customizes NewPrimaryHandler's behavior.
+type PrimaryHandlerOption func(*primaryHandlerOptions)
+
+type primaryHandlerOptions struct {
+	logConstructor LogConstructor
+}
+
+// WithLogConstructor overrides the LogConstructor used to build the logger
+// attached to each request's context. If not supplied, NewPrimaryHandler
+// uses newDefaultLogConstructor.
+func WithLogConstructor(lc LogConstructor) PrimaryHandlerOption {
+	return func(o *primaryHandlerOptions) {
+		if lc != nil {
+			o.logConstructor = lc
+		}
+	}
+}
+
+func NewPrimaryHandler(l log.Logger, v *viper.Viper, sw *ServerHandler, webhookSvc ancla.Service, metricsRegistry provider.Provider, router *mux.Router, setOptions ...PrimaryHandlerOption) (*mux.Router, error) {
+
+	denyList := append([]string{}, defaultSanitizeHeaders...)
+	denyList = append(denyList, v.GetStringSlice("log.sanitizeHeaders")...)
+
+	deviceIDPeekLimit := int64(defaultDeviceIDPeekLimit)
+	if v.IsSet("log.deviceIDPeekLimit") {
+		deviceIDPeekLimit = v.GetInt64("log.deviceIDPeekLimit")
+	}
+
+	options := primaryHandlerOptions{logConstructor: newDefaultLogConstructor(denyList, deviceIDPeekLimit)}
+	for _, setOption := range setOptions {
+		setOption(&options)
+	}
 
 	validator, err := getValidator(v)
 	if err != nil {
 		return nil, err
 	}
 
-	authHandler := handler.AuthorizationHandler{
-		HeaderName:          "Authorization",
-		ForbiddenStatusCode: 403,
-		Validator:           validator,
-		Logger:              l,
+	basculeCfg := newBasculeConfig(v)
+	capabilities, err := newCapabilitiesValidator(basculeCfg, metricsRegistry.NewCounter(capabilityCheckMetric))
+	if err != nil {
+		return nil, err
 	}
 
-	authorizationDecorator := alice.New(SetLogger(l), authHandler.Decorate)
+	constructor := basculehttp.NewConstructor(
+		basculehttp.WithTokenFactory("Bearer", legacyTokenFactory{scheme: "Bearer", validator: validator}),
+		basculehttp.WithTokenFactory("Basic", legacyTokenFactory{scheme: "Basic", validator: validator}),
+	)
 
-	return configServerRouter(router, authorizationDecorator, sw, webhookSvc, metricsRegistry), nil
-}
+	enforcer := basculehttp.NewEnforcer(
+		basculehttp.WithRules("Bearer", bascule.Validators{capabilities}),
+		basculehttp.WithRules("Basic", bascule.Validators{capabilities}),
+	)
 
-func configServerRouter(router *mux.Router, primaryHandler alice.Chain, serverWrapper *ServerHandler, webhookSvc ancla.Service, metricsRegistry provider.Provider) *mux.Router {
-	var singleContentType = func(r *http.Request, _ *mux.RouteMatch) bool {
-		return len(r.Header["Content-Type"]) == 1 // require single specification for Content-Type Header
+	// constructor/enforcer run before SetLogger so the default
+	// LogConstructor can pull the authenticated subject and partner IDs
+	// out of the request context.
+	authorizationDecorator := alice.New(constructor, enforcer, SetLogger(l, options.logConstructor))
+
+	registrars, err := newRouteRegistrars(v, sw)
+	if err != nil {
+		return nil, err
 	}
 
-	router.Handle("/"+fmt.Sprintf("%s/%s", baseURI, version)+"/notify", primaryHandler.Then(serverWrapper)).Methods("POST").HeadersRegexp("Content-Type", "application/msgpack").MatcherFunc(singleContentType)
+	return configServerRouter(router, authorizationDecorator, registrars, webhookSvc, metricsRegistry), nil
+}
+
+func configServerRouter(router *mux.Router, primaryHandler alice.Chain, registrars []RouteRegistrar, webhookSvc ancla.Service, metricsRegistry provider.Provider) *mux.Router {
+	for _, registrar := range registrars {
+		registrar.Register(router, primaryHandler)
+	}
 
 	addWebhookHandler := ancla.NewAddWebhookHandler(webhookSvc, ancla.HandlerConfig{
 		MetricsProvider: metricsRegistry,
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitizeHeaders(t *testing.T) {
+	denyList := []string{"Cookie", "X-Auth-Token", "Proxy-Authorization"}
+
+	t.Run("MissingAuthorization", func(t *testing.T) {
+		assert := assert.New(t)
+
+		header := http.Header{"X-Request-Id": []string{"abc"}}
+		sanitized := sanitizeHeaders(header, denyList)
+
+		assert.Equal([]string{"abc"}, sanitized["X-Request-Id"])
+		assert.Empty(sanitized[authorizationHeaderKey])
+		assert.Empty(sanitized[authorizationTypeHeaderKey])
+	})
+
+	t.Run("MalformedSingleToken", func(t *testing.T) {
+		assert := assert.New(t)
+
+		header := http.Header{authorizationHeaderKey: []string{"opaque-token-no-scheme"}}
+		sanitized := sanitizeHeaders(header, denyList)
+
+		assert.Empty(sanitized[authorizationHeaderKey])
+		assert.Equal([]string{"opaque-token-no-scheme"}, sanitized[authorizationTypeHeaderKey])
+	})
+
+	t.Run("MultiValued", func(t *testing.T) {
+		assert := assert.New(t)
+
+		header := http.Header{
+			authorizationHeaderKey: []string{"Bearer abc123", "Basic dXNlcjpwYXNz"},
+			"Cookie":               []string{"session=1"},
+		}
+		sanitized := sanitizeHeaders(header, denyList)
+
+		assert.Empty(sanitized[authorizationHeaderKey])
+		assert.Equal([]string{"Bearer", "Basic"}, sanitized[authorizationTypeHeaderKey])
+		assert.Empty(sanitized["Cookie"])
+	})
+
+	t.Run("ConfiguredDenyList", func(t *testing.T) {
+		assert := assert.New(t)
+
+		header := http.Header{"X-Custom-Secret": []string{"shh"}}
+		sanitized := sanitizeHeaders(header, []string{"X-Custom-Secret"})
+
+		assert.Empty(sanitized["X-Custom-Secret"])
+	})
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/justinas/alice"
+	"github.com/spf13/viper"
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+// decodeFunc parses a request body into a wrp.Message. nil means the
+// destination handler decodes the body itself (this is the case for the
+// historical msgpack route, since ServerHandler already speaks msgpack
+// natively).
+type decodeFunc func(io.Reader) (*wrp.Message, error)
+
+// RouteConfig is the viper-bound shape of one entry in primary.routes. It
+// describes a single API version/content-type combination.
+type RouteConfig struct {
+	// Version is the API version path segment, e.g. "v3".
+	Version string
+
+	// ContentType is the Content-Type header value this route requires,
+	// e.g. "application/msgpack". It must have a decoder registered for
+	// it in decodersByContentType.
+	ContentType string
+
+	// Method is the HTTP method this route accepts. Defaults to "POST".
+	Method string
+}
+
+// decodersByContentType maps a RouteConfig's ContentType to the decodeFunc
+// that understands it. Add an entry here when introducing support for a
+// new content type; which content types are actually installed as routes
+// is controlled separately via the primary.routes viper config.
+var decodersByContentType = map[string]decodeFunc{
+	"application/msgpack": nil,
+	"application/json": func(r io.Reader) (*wrp.Message, error) {
+		var msg wrp.Message
+		if err := wrp.NewDecoder(r, wrp.JSON).Decode(&msg); err != nil {
+			return nil, err
+		}
+		return &msg, nil
+	},
+}
+
+// defaultRouteConfigs preserves the historical behavior - a single v3
+// msgpack notify route - when primary.routes isn't configured.
+var defaultRouteConfigs = []RouteConfig{
+	{Version: version, ContentType: "application/msgpack"},
+}
+
+// RouteRegistrar installs one API version/content-type combination's
+// notify route onto router, wrapped by primaryHandler. Each combination
+// (e.g. v3-msgpack, v3-json) registers itself, which lets
+// operators enable or disable one via config rather than a recompile.
+type RouteRegistrar interface {
+	Register(router *mux.Router, primaryHandler alice.Chain)
+}
+
+// notifyRoute is the RouteRegistrar for a single notify version/content-type
+// combination.
+type notifyRoute struct {
+	cfg     RouteConfig
+	method  string
+	handler http.Handler
+}
+
+// newNotifyRoute builds the RouteRegistrar for cfg. When decode is non-nil,
+// the route's handler transcodes the body to msgpack before delegating to
+// sw; otherwise sw handles the body as-is.
+func newNotifyRoute(cfg RouteConfig, decode decodeFunc, sw *ServerHandler) notifyRoute {
+	method := cfg.Method
+	if method == "" {
+		method = "POST"
+	}
+
+	var handler http.Handler = sw
+	if decode != nil {
+		handler = transcodingHandler{decode: decode, next: sw}
+	}
+
+	return notifyRoute{cfg: cfg, method: method, handler: handler}
+}
+
+// Register implements RouteRegistrar.
+func (n notifyRoute) Register(router *mux.Router, primaryHandler alice.Chain) {
+	var singleContentType = func(r *http.Request, _ *mux.RouteMatch) bool {
+		return len(r.Header["Content-Type"]) == 1 // require single specification for Content-Type Header
+	}
+
+	router.Handle(fmt.Sprintf("/%s/%s/notify", baseURI, n.cfg.Version), primaryHandler.Then(n.handler)).
+		Methods(n.method).
+		HeadersRegexp("Content-Type", n.cfg.ContentType).
+		MatcherFunc(singleContentType)
+}
+
+// transcodingHandler adapts a request in a content type ServerHandler
+// doesn't natively understand by decoding it with decode and re-encoding
+// the result to msgpack before delegating to next.
+type transcodingHandler struct {
+	decode decodeFunc
+	next   http.Handler
+}
+
+func (t transcodingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	msg, err := t.decode(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid payload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := wrp.NewEncoder(&buf, wrp.Msgpack).Encode(msg); err != nil {
+		http.Error(w, fmt.Sprintf("failed to re-encode payload: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	r.Body = io.NopCloser(&buf)
+	r.ContentLength = int64(buf.Len())
+	r.Header.Set("Content-Type", "application/msgpack")
+	t.next.ServeHTTP(w, r)
+}
+
+// newRouteRegistrars builds the RouteRegistrar list from the primary.routes
+// viper config, falling back to defaultRouteConfigs when it's unset.
+func newRouteRegistrars(v *viper.Viper, sw *ServerHandler) ([]RouteRegistrar, error) {
+	var routeConfigs []RouteConfig
+	if err := v.UnmarshalKey("primary.routes", &routeConfigs); err != nil {
+		return nil, err
+	}
+	if len(routeConfigs) == 0 {
+		routeConfigs = defaultRouteConfigs
+	}
+
+	registrars := make([]RouteRegistrar, 0, len(routeConfigs))
+	for _, cfg := range routeConfigs {
+		decode, ok := decodersByContentType[cfg.ContentType]
+		if !ok {
+			return nil, fmt.Errorf("no decoder registered for content type %q", cfg.ContentType)
+		}
+
+		registrars = append(registrars, newNotifyRoute(cfg, decode, sw))
+	}
+
+	return registrars, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/justinas/alice"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/xmidt-org/wrp-go/v3"
+)
+
+func newRecordingHandler(body *[]byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func TestRouteRegistrarsDispatchByContentType(t *testing.T) {
+	var msgpackBody, jsonBody []byte
+
+	msgpackRoute := notifyRoute{
+		cfg:     RouteConfig{Version: "v3", ContentType: "application/msgpack"},
+		method:  "POST",
+		handler: newRecordingHandler(&msgpackBody),
+	}
+	jsonRoute := notifyRoute{
+		cfg:     RouteConfig{Version: "v3", ContentType: "application/json"},
+		method:  "POST",
+		handler: newRecordingHandler(&jsonBody),
+	}
+
+	router := mux.NewRouter()
+	chain := alice.New()
+	msgpackRoute.Register(router, chain)
+	jsonRoute.Register(router, chain)
+
+	post := func(contentType string, payload string) {
+		req := httptest.NewRequest("POST", "/api/v3/notify", bytes.NewBufferString(payload))
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	post("application/msgpack", "msgpack-payload")
+	post("application/json", `{"payload":"json"}`)
+
+	assert.Equal(t, "msgpack-payload", string(msgpackBody))
+	assert.Equal(t, `{"payload":"json"}`, string(jsonBody))
+}
+
+func TestTranscodingHandlerSetsMsgpackContentType(t *testing.T) {
+	var seenContentType string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seenContentType = r.Header.Get("Content-Type")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	handler := transcodingHandler{
+		decode: func(io.Reader) (*wrp.Message, error) {
+			return &wrp.Message{Destination: "mac:112233445566"}, nil
+		},
+		next: next,
+	}
+
+	req := httptest.NewRequest("POST", "/api/v3/notify", bytes.NewBufferString(`{"destination":"mac:112233445566"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/msgpack", seenContentType)
+}
+
+func TestNewRouteRegistrarsUnknownContentType(t *testing.T) {
+	v := viper.New()
+	v.Set("primary.routes", []map[string]interface{}{
+		{"version": "v4", "contentType": "application/cbor"},
+	})
+
+	registrars, err := newRouteRegistrars(v, nil)
+
+	assert.Nil(t, registrars)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "application/cbor")
+}